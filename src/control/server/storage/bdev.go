@@ -0,0 +1,383 @@
+//
+// (C) Copyright 2021 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/logging"
+)
+
+// NvmeController represents an NVMe device controller discovered on a host.
+type NvmeController struct {
+	Model       string
+	Serial      string
+	PciAddr     string
+	FwRev       string
+	SocketID    int32
+	HealthStats *NvmeHealth
+	Namespaces  []*NvmeNamespace
+	// Encrypted indicates that this controller's bdevs are wrapped in an
+	// SPDK bdev_crypto layer, i.e. encrypted at rest.
+	Encrypted bool
+}
+
+// NvmeHealth represents health statistics for an NvmeController.
+type NvmeHealth struct {
+	Temperature uint32
+}
+
+// NvmeNamespace represents an NVMe namespace belonging to a controller.
+type NvmeNamespace struct {
+	ID        uint32
+	Size      uint64
+	Encrypted bool
+}
+
+// NvmeControllers is a slice of NvmeController references.
+type NvmeControllers []*NvmeController
+
+// MockNvmeControllers returns a slice of mock NvmeController references,
+// for use in tests.
+func MockNvmeControllers(count int) NvmeControllers {
+	ncs := make(NvmeControllers, 0, count)
+	for i := 0; i < count; i++ {
+		ncs = append(ncs, &NvmeController{
+			Model:   fmt.Sprintf("model-%d", i),
+			Serial:  fmt.Sprintf("serial-%d", i),
+			PciAddr: fmt.Sprintf("0000:%02x:00.0", i),
+		})
+	}
+	return ncs
+}
+
+// BdevScanRequest defines the parameters for a bdev scan operation.
+type BdevScanRequest struct {
+	DeviceList  []string
+	BypassCache bool
+	// ExpectEncrypted records, per PCI address, the encryption state the
+	// current config expects a device to be in. A mismatch against the
+	// cached scan result forces a fresh scan even if BypassCache is not
+	// set, since a tier's encryption state is not something callers
+	// should have to know to bypass the cache for explicitly.
+	ExpectEncrypted map[string]bool
+}
+
+// BdevScanResponse contains the results of a bdev scan operation.
+type BdevScanResponse struct {
+	Controllers NvmeControllers
+}
+
+// bdevScanFn is the signature of a function that performs the actual bdev
+// scan, implemented by a BdevProvider.
+type bdevScanFn func(BdevScanRequest) (*BdevScanResponse, error)
+
+// encryptionStateStale returns true if any controller in ctrlrs has an
+// encryption state that no longer matches what the config expects of it.
+func encryptionStateStale(ctrlrs NvmeControllers, expect map[string]bool) bool {
+	for _, c := range ctrlrs {
+		if exp, ok := expect[c.PciAddr]; ok && exp != c.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// scanBdevs executes scanFn to retrieve the current set of bdevs, unless a
+// non-empty cached response is available, the request has not opted to
+// bypass the cache, and the cache's per-device encryption state still
+// matches what the config expects; otherwise the cached response is
+// returned as-is.
+func scanBdevs(log logging.Logger, req BdevScanRequest, cache *BdevScanResponse, scanFn bdevScanFn) (*BdevScanResponse, error) {
+	if !req.BypassCache && cache != nil && len(cache.Controllers) > 0 &&
+		!encryptionStateStale(cache.Controllers, req.ExpectEncrypted) {
+		log.Debug("returning cached bdev scan results")
+		return cache, nil
+	}
+
+	return scanFn(req)
+}
+
+// BdevTierProperties holds the resolved, concrete bdev configuration for a
+// single storage tier, as derived from a TierConfig.
+type BdevTierProperties struct {
+	Class          Class
+	DeviceList     []string
+	DeviceFileSize int
+	// Selector, when the tier was configured with one, is preserved here
+	// so that hotplug events can be matched against it directly instead
+	// of against a fixed numeric bus-ID window.
+	Selector *BdevSelector
+	// Encryption, when the tier was configured with it, is preserved here
+	// so that the generated SPDK JSON can stack a bdev_crypto layer over
+	// each of the tier's bdevs.
+	Encryption *BdevEncryption
+	// KeyRef is the reference returned by Encryption.KeyProvider at
+	// request-build time, suitable for writing into the generated SPDK
+	// JSON. It is empty unless Encryption is set.
+	KeyRef string
+}
+
+// AcceptsHotplugDevice reports whether a newly-inserted device should be
+// accepted into this tier. When the tier was configured with a selector,
+// the device must match it; otherwise it must fall within the numeric
+// [begin, end] bus-ID window.
+func (p BdevTierProperties) AcceptsHotplugDevice(dev PCIDevice, begin, end uint8) (bool, error) {
+	if p.Selector != nil {
+		ok, _, err := p.Selector.Matches(dev)
+		return ok, err
+	}
+
+	busid, err := parseHexBusid(dev.Busid)
+	if err != nil {
+		return false, err
+	}
+	return busid >= begin && busid <= end, nil
+}
+
+// BdevWriteConfigRequest defines the parameters for a request to write an
+// SPDK configuration file for the bdev tiers of an engine.
+type BdevWriteConfigRequest struct {
+	// ConfigOutputPath is the filesystem path the generated SPDK JSON is
+	// written to.
+	ConfigOutputPath  string
+	OwnerUID          int
+	OwnerGID          int
+	TierProps         []BdevTierProperties
+	Hostname          string
+	HotplugEnabled    bool
+	HotplugBusidBegin uint8
+	HotplugBusidEnd   uint8
+}
+
+// BdevWriteConfigResponse contains the results of a bdev config write
+// operation.
+type BdevWriteConfigResponse struct{}
+
+// TierForHotplugDevice is the entry point hotplug event handling calls to
+// decide whether a newly-inserted device should be accepted, and if so,
+// which tier it belongs to. A selector tier is matched directly via
+// BdevTierProperties.AcceptsHotplugDevice; all other bdev tiers share this
+// request's numeric HotplugBusidBegin/HotplugBusidEnd window.
+func (r BdevWriteConfigRequest) TierForHotplugDevice(dev PCIDevice) (*BdevTierProperties, error) {
+	if !r.HotplugEnabled {
+		return nil, nil
+	}
+
+	for i, props := range r.TierProps {
+		ok, err := props.AcceptsHotplugDevice(dev, r.HotplugBusidBegin, r.HotplugBusidEnd)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &r.TierProps[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// parseBusidRange parses a "0xBB-0xBB" PCI bus-ID range string into its
+// begin and end bounds.
+func parseBusidRange(rangeStr string) (begin, end uint8, err error) {
+	parts := strings.SplitN(rangeStr, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid busid range %q", rangeStr)
+	}
+
+	parseHex := func(s string) (uint8, error) {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+		v, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid busid %q", s)
+		}
+		return uint8(v), nil
+	}
+
+	if begin, err = parseHex(parts[0]); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseHex(parts[1]); err != nil {
+		return 0, 0, err
+	}
+	return begin, end, nil
+}
+
+// BdevWriteConfigRequestFromConfig builds a BdevWriteConfigRequest from the
+// bdev tiers of the supplied engine storage Config. When hotplug is enabled,
+// the hotplug bus-ID range of each non-selector tier is resolved either from
+// its explicit range or, when none was supplied, from the host topology.
+func BdevWriteConfigRequestFromConfig(ctx context.Context, log logging.Logger, cfg *Config, getTopoFn topologyGetter) (BdevWriteConfigRequest, error) {
+	if cfg == nil {
+		return BdevWriteConfigRequest{}, errors.New("nil config")
+	}
+	if getTopoFn == nil {
+		return BdevWriteConfigRequest{}, errors.New("nil GetTopology")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return BdevWriteConfigRequest{}, err
+	}
+
+	req := BdevWriteConfigRequest{
+		ConfigOutputPath: cfg.ConfigOutputPath,
+		OwnerUID:         os.Geteuid(),
+		OwnerGID:         os.Getegid(),
+		TierProps:        []BdevTierProperties{},
+		Hostname:         hostname,
+		HotplugEnabled:   cfg.EnableHotplug,
+	}
+
+	for _, tc := range cfg.Tiers.BdevConfigs() {
+		if tc.Bdev.Selector != nil && len(tc.Bdev.DeviceList) > 0 {
+			return BdevWriteConfigRequest{}, errors.Errorf(
+				"tier configured with both bdev_list and bdev_selector; remove one")
+		}
+
+		props := BdevTierProperties{
+			Class:      tc.Class,
+			DeviceList: tc.Bdev.DeviceList,
+		}
+
+		if tc.Bdev.Selector != nil {
+			topo, err := getTopoFn(ctx)
+			if err != nil {
+				return BdevWriteConfigRequest{}, err
+			}
+
+			matched, err := tc.Bdev.Selector.Resolve(topo)
+			if err != nil {
+				return BdevWriteConfigRequest{}, errors.Wrap(err, "resolving bdev selector")
+			}
+
+			devList := make([]string, len(matched))
+			for i, dev := range matched {
+				devList[i] = dev.Busid
+			}
+			props.DeviceList = devList
+			props.Selector = tc.Bdev.Selector
+		}
+
+		if tc.Bdev.Encryption != nil {
+			keyRef, err := tc.Bdev.Encryption.KeyProvider.FetchKeyRef(ctx)
+			if err != nil {
+				return BdevWriteConfigRequest{}, errors.Wrap(err, "fetching bdev encryption key")
+			}
+			props.Encryption = tc.Bdev.Encryption
+			props.KeyRef = keyRef
+		}
+
+		req.TierProps = append(req.TierProps, props)
+
+		if !cfg.EnableHotplug || tc.Bdev.Selector != nil {
+			// A selector governs hotplug acceptance directly via
+			// BdevTierProperties.AcceptsHotplugDevice, so no
+			// numeric bus-ID window is needed for this tier.
+			continue
+		}
+
+		if tc.Bdev.BusidRange != "" {
+			begin, end, err := parseBusidRange(tc.Bdev.BusidRange)
+			if err != nil {
+				return BdevWriteConfigRequest{}, err
+			}
+			req.HotplugBusidBegin, req.HotplugBusidEnd = begin, end
+			continue
+		}
+
+		topo, err := getTopoFn(ctx)
+		if err != nil {
+			return BdevWriteConfigRequest{}, err
+		}
+		req.HotplugBusidBegin, req.HotplugBusidEnd = defaultHotplugRange(topo)
+	}
+
+	return req, nil
+}
+
+// spdkMethod is a single entry in the "bdev_subsystem_config" array of an
+// SPDK JSON config document.
+type spdkMethod struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// ToSpdkJSON renders the SPDK bdev subsystem configuration described by
+// this request. Devices belonging to a tier configured with Encryption are
+// attached as normal and then wrapped in a bdev_crypto bdev stacked on top,
+// named after the underlying device; only the key reference returned by
+// the tier's KeyProvider is written out, never key material.
+func (r BdevWriteConfigRequest) ToSpdkJSON() ([]byte, error) {
+	var methods []spdkMethod
+
+	for _, props := range r.TierProps {
+		for _, dev := range props.DeviceList {
+			baseName := "Nvme_" + dev
+			methods = append(methods, spdkMethod{
+				Method: "bdev_nvme_attach_controller",
+				Params: map[string]interface{}{
+					"name":   baseName,
+					"traddr": dev,
+					"trtype": "PCIe",
+				},
+			})
+
+			if props.Encryption == nil {
+				continue
+			}
+
+			methods = append(methods, spdkMethod{
+				Method: "bdev_crypto_create",
+				Params: map[string]interface{}{
+					"base_bdev_name": baseName + "n1",
+					"name":           baseName + "n1_crypto",
+					"crypto_pmd":     "crypto_aesni_mb",
+					"cipher":         props.Encryption.Cipher,
+					"key_name":       props.KeyRef,
+				},
+			})
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"subsystems": []map[string]interface{}{
+			{
+				"subsystem": "bdev",
+				"config":    methods,
+			},
+		},
+	})
+}
+
+// writeSpdkConfig renders r as SPDK JSON and persists it to
+// r.ConfigOutputPath, chown'd to r.OwnerUID/r.OwnerGID so that the DAOS
+// engine process, which drops privileges to that user, can read it back.
+func writeSpdkConfig(r BdevWriteConfigRequest) error {
+	if r.ConfigOutputPath == "" {
+		return errors.New("write SPDK config: missing config output path")
+	}
+
+	data, err := r.ToSpdkJSON()
+	if err != nil {
+		return errors.Wrap(err, "generating SPDK config")
+	}
+
+	if err := os.WriteFile(r.ConfigOutputPath, data, 0644); err != nil {
+		return errors.Wrapf(err, "write %q", r.ConfigOutputPath)
+	}
+
+	return errors.Wrapf(os.Chown(r.ConfigOutputPath, r.OwnerUID, r.OwnerGID),
+		"set ownership of %q to %d.%d", r.ConfigOutputPath, r.OwnerUID, r.OwnerGID)
+}
@@ -0,0 +1,164 @@
+//
+// (C) Copyright 2021 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Class indicates a specific storage class.
+type Class string
+
+// Class type definitions.
+const (
+	ClassNone Class = ""
+	ClassDcpm Class = "dcpm"
+	ClassRam  Class = "ram"
+	ClassNvme Class = "nvme"
+	ClassFile Class = "file"
+	ClassKdev Class = "kdev"
+)
+
+func (c Class) String() string {
+	return string(c)
+}
+
+// TierConfig encapsulates a storage tier configuration entry.
+type TierConfig struct {
+	Tier  int            `yaml:"-"`
+	Class Class          `yaml:"class"`
+	Scm   ScmTierConfig  `yaml:",inline"`
+	Bdev  BdevTierConfig `yaml:",inline"`
+}
+
+// ScmTierConfig holds scm-specific tier configuration.
+type ScmTierConfig struct {
+	MountPoint  string   `yaml:"scm_mount,omitempty"`
+	DeviceList  []string `yaml:"scm_list,omitempty"`
+	RamdiskSize int      `yaml:"scm_size,omitempty"`
+}
+
+// BdevTierConfig holds bdev-specific tier configuration.
+type BdevTierConfig struct {
+	DeviceList  []string `yaml:"bdev_list,omitempty"`
+	BusidRange  string   `yaml:"bdev_busid_range,omitempty"`
+	DeviceCount int      `yaml:"bdev_number,omitempty"`
+	FileSize    int      `yaml:"bdev_size,omitempty"`
+	// OpiBackend, when set, offloads this tier's bdevs to a remote
+	// DPU/IPU speaking the OPI storage gRPC API instead of driving SPDK
+	// against devices attached to the local host.
+	OpiBackend *OpiBackendConfig `yaml:"bdev_backend,omitempty"`
+	// Selector, when set, declaratively matches bdev candidates against
+	// topology attributes in place of DeviceList/BusidRange.
+	Selector *BdevSelector `yaml:"bdev_selector,omitempty"`
+	// Encryption, when set, declares that this tier's devices are to be
+	// encrypted at rest. It is set only via WithBdevEncryption and is not
+	// read from yaml.
+	Encryption *BdevEncryption `yaml:"-"`
+}
+
+// NewTierConfig returns a newly initialized TierConfig.
+func NewTierConfig() *TierConfig {
+	return &TierConfig{}
+}
+
+// WithStorageClass sets the storage class for this tier.
+func (tc *TierConfig) WithStorageClass(class string) *TierConfig {
+	tc.Class = Class(class)
+	return tc
+}
+
+// WithScmMountPoint sets the scm mount point for this tier.
+func (tc *TierConfig) WithScmMountPoint(mp string) *TierConfig {
+	tc.Scm.MountPoint = mp
+	return tc
+}
+
+// WithScmDeviceList sets the scm device list for this tier.
+func (tc *TierConfig) WithScmDeviceList(devs ...string) *TierConfig {
+	tc.Scm.DeviceList = append(tc.Scm.DeviceList, devs...)
+	return tc
+}
+
+// WithBdevDeviceList sets the bdev device list for this tier.
+func (tc *TierConfig) WithBdevDeviceList(devs ...string) *TierConfig {
+	tc.Bdev.DeviceList = append(tc.Bdev.DeviceList, devs...)
+	return tc
+}
+
+// WithBdevBusidRange sets the bus-ID range to be used to filter hotplug
+// events for this tier.
+func (tc *TierConfig) WithBdevBusidRange(rangeStr string) *TierConfig {
+	tc.Bdev.BusidRange = strings.TrimSpace(rangeStr)
+	return tc
+}
+
+// WithBdevOpiBackend configures this tier's bdevs to be provisioned and
+// scanned via a remote OPI storage gRPC endpoint rather than local SPDK.
+func (tc *TierConfig) WithBdevOpiBackend(cfg *OpiBackendConfig) *TierConfig {
+	tc.Bdev.OpiBackend = cfg
+	return tc
+}
+
+// WithBdevSelector configures this tier's bdevs to be resolved
+// declaratively against the host topology rather than via an explicit
+// device list or bus-ID range.
+func (tc *TierConfig) WithBdevSelector(sel *BdevSelector) *TierConfig {
+	tc.Bdev.Selector = sel
+	return tc
+}
+
+// WithBdevEncryption declares this tier's devices to be encrypted at rest,
+// with key material sourced from keyProvider and the given cipher used to
+// wrap each bdev.
+func (tc *TierConfig) WithBdevEncryption(keyProvider KeyProvider, cipher string) *TierConfig {
+	tc.Bdev.Encryption = &BdevEncryption{KeyProvider: keyProvider, Cipher: cipher}
+	return tc
+}
+
+// IsSCM returns true if the tier is a SCM tier.
+func (tc *TierConfig) IsSCM() bool {
+	return tc.Class == ClassDcpm || tc.Class == ClassRam
+}
+
+// IsBdev returns true if the tier is a bdev tier.
+func (tc *TierConfig) IsBdev() bool {
+	return tc.Class == ClassNvme || tc.Class == ClassFile || tc.Class == ClassKdev
+}
+
+// TierConfigs is a slice of TierConfig references.
+type TierConfigs []*TierConfig
+
+// BdevConfigs returns the subset of tiers that are bdev tiers.
+func (tcs TierConfigs) BdevConfigs() (out TierConfigs) {
+	for _, tc := range tcs {
+		if tc.IsBdev() {
+			out = append(out, tc)
+		}
+	}
+	return
+}
+
+// Config encapsulates the storage configuration for an engine.
+type Config struct {
+	Tiers         TierConfigs `yaml:"storage"`
+	EnableHotplug bool        `yaml:"enable_hotplug"`
+	// ConfigOutputPath is the filesystem path the generated SPDK JSON
+	// config is written to. It is not read from yaml and is empty unless
+	// set by the caller.
+	ConfigOutputPath string `yaml:"-"`
+}
+
+// Validate checks the storage configuration for inconsistencies.
+func (c *Config) Validate() error {
+	if c == nil {
+		return errors.New("nil config")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//
+// (C) Copyright 2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyProvider fetches the reference to a key held by some key management
+// backend. Implementations must never return or persist the raw key
+// material; only a reference safe to write into an SPDK keyring-backed
+// configuration is handed back to the caller.
+type KeyProvider interface {
+	// FetchKeyRef returns an opaque reference to the key (a keyring
+	// name, a KMIP/Vault key identifier, etc.) suitable for inclusion in
+	// generated SPDK JSON.
+	FetchKeyRef(ctx context.Context) (string, error)
+}
+
+// fileKeyProvider sources key material from a local file readable only by
+// the engine, referencing it in generated configs by the file's path.
+type fileKeyProvider struct {
+	path string
+}
+
+// NewFileKeyProvider returns a KeyProvider that reads key material from a
+// local file.
+func NewFileKeyProvider(path string) KeyProvider {
+	return &fileKeyProvider{path: path}
+}
+
+func (p *fileKeyProvider) FetchKeyRef(_ context.Context) (string, error) {
+	if _, err := os.ReadFile(p.path); err != nil {
+		return "", errors.Wrapf(err, "fetch key from file %q", p.path)
+	}
+	return "file:" + p.path, nil
+}
+
+// envKeyProvider sources key material from an environment variable set in
+// the engine's process environment.
+type envKeyProvider struct {
+	varName string
+}
+
+// NewEnvKeyProvider returns a KeyProvider that reads key material from the
+// named environment variable.
+func NewEnvKeyProvider(varName string) KeyProvider {
+	return &envKeyProvider{varName: varName}
+}
+
+func (p *envKeyProvider) FetchKeyRef(_ context.Context) (string, error) {
+	if _, ok := os.LookupEnv(p.varName); !ok {
+		return "", errors.Errorf("fetch key from env: %q is not set", p.varName)
+	}
+	return "env:" + p.varName, nil
+}
+
+// kmipKeyProvider references a key held by a KMIP-compliant key manager.
+type kmipKeyProvider struct {
+	endpoint string
+	keyID    string
+}
+
+// NewKmipKeyProvider returns a KeyProvider that references a key on a KMIP
+// server by its key ID. FetchKeyRef only checks that the endpoint and key ID
+// are set and returns "kmip:<keyID>"; it does not contact the server.
+func NewKmipKeyProvider(endpoint, keyID string) KeyProvider {
+	return &kmipKeyProvider{endpoint: endpoint, keyID: keyID}
+}
+
+func (p *kmipKeyProvider) FetchKeyRef(_ context.Context) (string, error) {
+	if p.endpoint == "" || p.keyID == "" {
+		return "", errors.New("fetch key from kmip: endpoint and key_id are required")
+	}
+	return "kmip:" + p.keyID, nil
+}
+
+// vaultKeyProvider references a key held in a HashiCorp Vault secrets
+// engine.
+type vaultKeyProvider struct {
+	addr string
+	path string
+}
+
+// NewVaultKeyProvider returns a KeyProvider that references a key in Vault by
+// its secret path. FetchKeyRef only checks that the address and path are set
+// and returns "vault:<path>"; it does not contact Vault.
+func NewVaultKeyProvider(addr, path string) KeyProvider {
+	return &vaultKeyProvider{addr: addr, path: path}
+}
+
+func (p *vaultKeyProvider) FetchKeyRef(_ context.Context) (string, error) {
+	if p.addr == "" || p.path == "" {
+		return "", errors.New("fetch key from vault: addr and path are required")
+	}
+	return "vault:" + strings.TrimPrefix(p.path, "/"), nil
+}
+
+// BdevEncryption declares that a bdev tier's devices should be encrypted
+// at rest, LUKS-style, by stacking an SPDK bdev_crypto layer over each
+// underlying NVMe bdev.
+type BdevEncryption struct {
+	// KeyProvider supplies the reference to the key used to unlock the
+	// tier's devices.
+	KeyProvider KeyProvider
+	// Cipher names the SPDK crypto bdev cipher to use, e.g. "AES_XTS".
+	Cipher string
+}
@@ -0,0 +1,160 @@
+//
+// (C) Copyright 2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/common"
+)
+
+func Test_KeyProviders_FetchKeyRef(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "tier0.key")
+	if err := os.WriteFile(keyFile, []byte("super-secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DAOS_TEST_KEY", "super-secret")
+	defer os.Unsetenv("DAOS_TEST_KEY")
+
+	for name, tc := range map[string]struct {
+		provider  KeyProvider
+		expKeyRef string
+		expErr    error
+	}{
+		"file: present": {
+			provider:  NewFileKeyProvider(keyFile),
+			expKeyRef: "file:" + keyFile,
+		},
+		"file: missing": {
+			provider: NewFileKeyProvider(filepath.Join(t.TempDir(), "missing.key")),
+			expErr:   errors.New("fetch key from file"),
+		},
+		"env: present": {
+			provider:  NewEnvKeyProvider("DAOS_TEST_KEY"),
+			expKeyRef: "env:DAOS_TEST_KEY",
+		},
+		"env: missing": {
+			provider: NewEnvKeyProvider("DAOS_TEST_KEY_UNSET"),
+			expErr:   errors.New("is not set"),
+		},
+		"kmip: configured": {
+			provider:  NewKmipKeyProvider("kmip.example.com:5696", "key-1"),
+			expKeyRef: "kmip:key-1",
+		},
+		"kmip: missing key id": {
+			provider: NewKmipKeyProvider("kmip.example.com:5696", ""),
+			expErr:   errors.New("endpoint and key_id are required"),
+		},
+		"vault: configured": {
+			provider:  NewVaultKeyProvider("https://vault.example.com", "secret/daos/tier0"),
+			expKeyRef: "vault:secret/daos/tier0",
+		},
+		"vault: missing path": {
+			provider: NewVaultKeyProvider("https://vault.example.com", ""),
+			expErr:   errors.New("addr and path are required"),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			keyRef, err := tc.provider.FetchKeyRef(context.Background())
+			common.CmpErr(t, tc.expErr, err)
+			if err != nil {
+				return
+			}
+			if keyRef != tc.expKeyRef {
+				t.Fatalf("expected key ref %q, got %q", tc.expKeyRef, keyRef)
+			}
+		})
+	}
+}
+
+// spdkMethodJSON mirrors the shape of a single "bdev" subsystem config
+// entry in generated SPDK JSON, for decoding in tests.
+type spdkMethodJSON struct {
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// spdkConfigDocJSON mirrors the top-level shape of generated SPDK JSON, for
+// decoding in tests.
+type spdkConfigDocJSON struct {
+	Subsystems []struct {
+		Subsystem string           `json:"subsystem"`
+		Config    []spdkMethodJSON `json:"config"`
+	} `json:"subsystems"`
+}
+
+func Test_BdevWriteConfigRequest_ToSpdkJSON(t *testing.T) {
+	req := BdevWriteConfigRequest{
+		TierProps: []BdevTierProperties{
+			{
+				Class:      ClassNvme,
+				DeviceList: []string{"0000:70:00.0"},
+			},
+			{
+				Class:      ClassNvme,
+				DeviceList: []string{"0000:71:00.0"},
+				Encryption: &BdevEncryption{Cipher: "AES_XTS"},
+				KeyRef:     "env:DAOS_TEST_KEY",
+			},
+		},
+	}
+
+	out, err := req.ToSpdkJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var got spdkConfigDocJSON
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("unmarshaling generated SPDK JSON: %s", err)
+	}
+
+	if len(got.Subsystems) != 1 || got.Subsystems[0].Subsystem != "bdev" {
+		t.Fatalf("expected a single \"bdev\" subsystem, got %+v", got.Subsystems)
+	}
+
+	expMethods := []spdkMethodJSON{
+		{
+			Method: "bdev_nvme_attach_controller",
+			Params: map[string]interface{}{
+				"name":   "Nvme_0000:70:00.0",
+				"traddr": "0000:70:00.0",
+				"trtype": "PCIe",
+			},
+		},
+		{
+			Method: "bdev_nvme_attach_controller",
+			Params: map[string]interface{}{
+				"name":   "Nvme_0000:71:00.0",
+				"traddr": "0000:71:00.0",
+				"trtype": "PCIe",
+			},
+		},
+		{
+			Method: "bdev_crypto_create",
+			Params: map[string]interface{}{
+				"base_bdev_name": "Nvme_0000:71:00.0n1",
+				"name":           "Nvme_0000:71:00.0n1_crypto",
+				"crypto_pmd":     "crypto_aesni_mb",
+				"cipher":         "AES_XTS",
+				"key_name":       "env:DAOS_TEST_KEY",
+			},
+		},
+	}
+
+	if diff := cmp.Diff(expMethods, got.Subsystems[0].Config); diff != "" {
+		t.Fatalf("unexpected bdev subsystem config (-want, +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,370 @@
+//
+// (C) Copyright 2021-2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/logging"
+)
+
+// opiHealthCheckInterval is how often an opiBdevProvider probes its
+// connection to the remote OPI endpoint in order to detect and recover
+// from a dropped DPU/IPU connection.
+const opiHealthCheckInterval = 30 * time.Second
+
+// BdevFormatRequest defines the parameters for a bdev format operation.
+type BdevFormatRequest struct {
+	Properties BdevTierProperties
+}
+
+// BdevFormatResponse contains the results of a bdev format operation.
+type BdevFormatResponse struct {
+	Formatted bool
+}
+
+// BdevPrepareRequest defines the parameters for a bdev prepare operation,
+// e.g. unbinding devices from the kernel driver ahead of SPDK use.
+type BdevPrepareRequest struct {
+	TargetUser string
+	Reset_     bool
+}
+
+// BdevPrepareResponse contains the results of a bdev prepare operation.
+type BdevPrepareResponse struct {
+	VMDPrepared bool
+}
+
+// BdevProvider defines an interface to be implemented by a Block Device
+// provider. The local SPDK backend drives devices attached to the local
+// host directly, while the OPI backend offloads the same operations to a
+// remote DPU/IPU over gRPC.
+type BdevProvider interface {
+	Scan(BdevScanRequest) (*BdevScanResponse, error)
+	WriteConfig(BdevWriteConfigRequest) (*BdevWriteConfigResponse, error)
+	Format(BdevFormatRequest) (*BdevFormatResponse, error)
+	Prepare(BdevPrepareRequest) (*BdevPrepareResponse, error)
+	// Close releases any resources held by the provider, e.g. an
+	// opiBdevProvider's gRPC connection and its background health loop.
+	// Callers that rebuild a tier's provider (config reload, tier
+	// removal) must Close the old one before discarding it.
+	Close() error
+}
+
+// localBdevProvider implements BdevProvider against bdevs attached to the
+// local host via SPDK.
+type localBdevProvider struct {
+	log   logging.Logger
+	cache *BdevScanResponse
+	// scanFn performs the actual SPDK scan; overridden in tests.
+	scanFn bdevScanFn
+	// expectEncrypted seeds each Scan's BdevScanRequest.ExpectEncrypted
+	// from the TierConfig this provider was built for, so that a caller
+	// that doesn't track a tier's encryption config itself still gets
+	// cache invalidation on an encryption-state change.
+	expectEncrypted map[string]bool
+}
+
+// NewBdevProvider returns a BdevProvider appropriate for the supplied tier
+// configuration. Tiers configured with a "bdev_backend: opi" block are
+// served by an opiBdevProvider dialed against the configured DPU endpoint;
+// all other tiers fall back to the local SPDK provider.
+func NewBdevProvider(log logging.Logger, tc *TierConfig) (BdevProvider, error) {
+	if tc != nil && tc.Bdev.OpiBackend != nil {
+		return newOpiBdevProvider(log, tc.Bdev.OpiBackend)
+	}
+
+	return &localBdevProvider{
+		log:             log,
+		scanFn:          scanLocalSpdkBdevs,
+		expectEncrypted: expectEncryptedFromTier(tc),
+	}, nil
+}
+
+// expectEncryptedFromTier builds the PCI-address -> expected-encryption
+// map for tc's explicitly listed bdevs. Selector-resolved devices aren't in
+// scope here, since their PCI addresses aren't known until the host
+// topology is resolved at request-build time; a selector tier relies on its
+// caller setting BdevScanRequest.ExpectEncrypted explicitly instead.
+func expectEncryptedFromTier(tc *TierConfig) map[string]bool {
+	if tc == nil || tc.Bdev.Selector != nil {
+		return nil
+	}
+
+	encrypted := tc.Bdev.Encryption != nil
+	expect := make(map[string]bool, len(tc.Bdev.DeviceList))
+	for _, addr := range tc.Bdev.DeviceList {
+		expect[addr] = encrypted
+	}
+
+	return expect
+}
+
+// scanLocalSpdkBdevs is the default scanFn for a localBdevProvider. Driving
+// an actual SPDK scan requires the cgo/SPDK env bindings, which this package
+// does not wire in; this default exists only so that a provider built via
+// NewBdevProvider fails loudly rather than crashing on a nil scanFn.
+func scanLocalSpdkBdevs(req BdevScanRequest) (*BdevScanResponse, error) {
+	return nil, errors.New("local SPDK bdev scan is not available in this build")
+}
+
+func (p *localBdevProvider) Scan(req BdevScanRequest) (*BdevScanResponse, error) {
+	if req.ExpectEncrypted == nil {
+		req.ExpectEncrypted = p.expectEncrypted
+	}
+
+	resp, err := scanBdevs(p.log, req, p.cache, p.scanFn)
+	if err != nil {
+		return nil, err
+	}
+	p.cache = resp
+
+	return resp, nil
+}
+
+// WriteConfig renders req as SPDK JSON and persists it to
+// req.ConfigOutputPath so that an actual SPDK-driven engine can pick up the
+// selector-resolved/encrypted tier properties built into req.TierProps.
+func (p *localBdevProvider) WriteConfig(req BdevWriteConfigRequest) (*BdevWriteConfigResponse, error) {
+	if err := writeSpdkConfig(req); err != nil {
+		return nil, err
+	}
+
+	return &BdevWriteConfigResponse{}, nil
+}
+
+func (p *localBdevProvider) Format(req BdevFormatRequest) (*BdevFormatResponse, error) {
+	return &BdevFormatResponse{Formatted: true}, nil
+}
+
+func (p *localBdevProvider) Prepare(req BdevPrepareRequest) (*BdevPrepareResponse, error) {
+	return &BdevPrepareResponse{}, nil
+}
+
+// Close is a no-op for localBdevProvider, which holds no long-lived
+// resources; it exists to satisfy BdevProvider.
+func (p *localBdevProvider) Close() error {
+	return nil
+}
+
+// OpiBackendConfig describes how to reach an OPI-compliant storage gRPC
+// endpoint hosted on a DPU/IPU, and the NVMf transport parameters to use
+// for bdevs offloaded to it.
+type OpiBackendConfig struct {
+	// TargetAddr is the "host:port" of the OPI storage gRPC endpoint.
+	TargetAddr string `yaml:"target_addr"`
+	// TLSEnabled selects transport-security creds over insecure ones.
+	TLSEnabled bool `yaml:"tls_enabled,omitempty"`
+	// TLSCertPath is the path to the client certificate used to dial the
+	// endpoint when TLSEnabled is set.
+	TLSCertPath string `yaml:"tls_cert,omitempty"`
+	// TLSKeyPath is the path to the client key used to dial the endpoint
+	// when TLSEnabled is set.
+	TLSKeyPath string `yaml:"tls_key,omitempty"`
+	// NvmfTransport is the NVMf transport (e.g. "tcp", "rdma") to request
+	// when attaching bdevs hosted on this tier.
+	NvmfTransport string `yaml:"nvmf_transport,omitempty"`
+}
+
+// opiBdevProvider implements BdevProvider by translating requests into
+// calls against an OPI (Open Programmable Infrastructure) storage gRPC
+// service hosted on a DPU/IPU, rather than driving SPDK locally.
+type opiBdevProvider struct {
+	log    logging.Logger
+	cfg    *OpiBackendConfig
+	dialFn func(ctx context.Context, cfg *OpiBackendConfig) (opiNvmeClient, error)
+
+	mu                  sync.Mutex
+	client              opiNvmeClient
+	healthLoopOn        bool
+	healthCheckInterval time.Duration
+	stopHealthLoop      chan struct{}
+}
+
+// opiNvmeClient is the subset of the OPI NvmeControllerService/FrontendNvme
+// gRPC clients needed to service a BdevProvider. It is defined locally so
+// that provider.go does not depend directly on generated OPI protobuf
+// stubs. No implementation backed by a live OPI endpoint exists in this
+// tree; see dialOpiNvme.
+type opiNvmeClient interface {
+	ListSubsystems(ctx context.Context) ([]*NvmeController, error)
+	CreateBdev(ctx context.Context, props BdevTierProperties) error
+	Close() error
+}
+
+// newOpiBdevProvider returns a BdevProvider that drives the configured OPI
+// endpoint; it does not dial. The connection is established lazily on first
+// use; a background health loop drops a connection whose probe fails, and
+// the next use redials, so that a transient DPU outage does not require a
+// control-plane restart.
+func newOpiBdevProvider(log logging.Logger, cfg *OpiBackendConfig) (*opiBdevProvider, error) {
+	if cfg == nil {
+		return nil, errors.New("nil OPI backend config")
+	}
+	if cfg.TargetAddr == "" {
+		return nil, errors.New("OPI backend config missing target_addr")
+	}
+
+	return &opiBdevProvider{
+		log:                 log,
+		cfg:                 cfg,
+		dialFn:              dialOpiNvme,
+		healthCheckInterval: opiHealthCheckInterval,
+	}, nil
+}
+
+// connect lazily dials the OPI endpoint, reusing an existing connection
+// where possible, and starts the background health/reconnect loop the
+// first time a connection is established.
+func (p *opiBdevProvider) connect(ctx context.Context) (opiNvmeClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil {
+		return p.client, nil
+	}
+
+	client, err := p.dialFn(ctx, p.cfg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial OPI endpoint %q", p.cfg.TargetAddr)
+	}
+	p.client = client
+
+	if !p.healthLoopOn {
+		p.healthLoopOn = true
+		p.stopHealthLoop = make(chan struct{})
+		go p.healthLoop(p.stopHealthLoop)
+	}
+
+	return client, nil
+}
+
+// healthLoop periodically probes the current OPI connection and drops it on
+// failure so that the next call to connect() redials, recovering from a
+// transient DPU outage without requiring a control-plane restart. It runs
+// until stop is closed by Close().
+func (p *opiBdevProvider) healthLoop(stop chan struct{}) {
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		client := p.client
+		p.mu.Unlock()
+
+		if client == nil {
+			continue
+		}
+
+		if _, err := client.ListSubsystems(context.Background()); err != nil {
+			p.log.Errorf("OPI endpoint %q health check failed, will reconnect: %s", p.cfg.TargetAddr, err)
+
+			p.mu.Lock()
+			if p.client == client {
+				client.Close()
+				p.client = nil
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Close stops the background health/reconnect loop, if running, and closes
+// the current OPI connection, if any. It is safe to call more than once.
+func (p *opiBdevProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.healthLoopOn {
+		close(p.stopHealthLoop)
+		p.healthLoopOn = false
+	}
+
+	if p.client == nil {
+		return nil
+	}
+
+	err := p.client.Close()
+	p.client = nil
+
+	return errors.Wrap(err, "closing OPI client")
+}
+
+// Scan lists NVMe subsystems/controllers/namespaces known to the remote
+// OPI endpoint and translates them into the NvmeControllers type consumed
+// by BdevScanResponse.
+func (p *opiBdevProvider) Scan(req BdevScanRequest) (*BdevScanResponse, error) {
+	ctx := context.Background()
+
+	client, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrlrs, err := client.ListSubsystems(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "OPI ListSubsystems")
+	}
+
+	return &BdevScanResponse{Controllers: NvmeControllers(ctrlrs)}, nil
+}
+
+// WriteConfig creates/attaches bdevs on the remote OPI endpoint for each of
+// the tier's configured devices, in place of writing a local SPDK JSON
+// config file.
+func (p *opiBdevProvider) WriteConfig(req BdevWriteConfigRequest) (*BdevWriteConfigResponse, error) {
+	ctx := context.Background()
+
+	client, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tierProps := range req.TierProps {
+		if err := client.CreateBdev(ctx, tierProps); err != nil {
+			return nil, errors.Wrap(err, "OPI CreateBdev")
+		}
+	}
+
+	return &BdevWriteConfigResponse{}, nil
+}
+
+func (p *opiBdevProvider) Format(req BdevFormatRequest) (*BdevFormatResponse, error) {
+	return &BdevFormatResponse{Formatted: true}, nil
+}
+
+func (p *opiBdevProvider) Prepare(req BdevPrepareRequest) (*BdevPrepareResponse, error) {
+	// No local PCIe access is required when bdevs are offloaded to a DPU,
+	// so there is nothing to unbind/rebind on the control-plane host.
+	return &BdevPrepareResponse{}, nil
+}
+
+// dialOpiNvme dials the OPI storage gRPC endpoint described by cfg and
+// returns a client implementing the NvmeControllerService/FrontendNvme RPCs
+// behind the opiNvmeClient interface. It is a variable so tests can
+// substitute a fake client without a live DPU.
+//
+// The generated OPI protobuf client this function would dial through is not
+// yet vendored in this tree, so this stub deliberately errors rather than
+// silently falling back to local SPDK behavior; callers configuring
+// bdev_backend: opi will get a clear failure instead of a provider that
+// looks offloaded but isn't. Wiring up the real dial (including TLSEnabled/
+// TLSCertPath/TLSKeyPath/NvmfTransport from cfg) is tracked as follow-up
+// work once that client is available.
+var dialOpiNvme = func(ctx context.Context, cfg *OpiBackendConfig) (opiNvmeClient, error) {
+	return nil, errors.Errorf("OPI backend not available in this build (target_addr %q)", cfg.TargetAddr)
+}
@@ -0,0 +1,277 @@
+//
+// (C) Copyright 2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/logging"
+)
+
+func TestProvider_NewBdevProvider(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tierCfg   *TierConfig
+		expOpi    bool
+		expErrMsg string
+	}{
+		"nil tier config": {
+			tierCfg: nil,
+			expOpi:  false,
+		},
+		"no backend configured": {
+			tierCfg: NewTierConfig().WithStorageClass(ClassNvme.String()),
+			expOpi:  false,
+		},
+		"opi backend configured": {
+			tierCfg: NewTierConfig().WithStorageClass(ClassNvme.String()).
+				WithBdevOpiBackend(&OpiBackendConfig{TargetAddr: "dpu1:50051"}),
+			expOpi: true,
+		},
+		"opi backend missing target address": {
+			tierCfg: NewTierConfig().WithStorageClass(ClassNvme.String()).
+				WithBdevOpiBackend(&OpiBackendConfig{}),
+			expErrMsg: "missing target_addr",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			log, buf := logging.NewTestLogger(name)
+			defer func() {
+				if t.Failed() {
+					t.Log(buf.String())
+				}
+			}()
+
+			provider, err := NewBdevProvider(log, tc.tierCfg)
+			if tc.expErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expErrMsg) {
+					t.Fatalf("expected error containing %q, got %v", tc.expErrMsg, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			_, isOpi := provider.(*opiBdevProvider)
+			if isOpi != tc.expOpi {
+				t.Fatalf("expected opi provider=%v, got %v", tc.expOpi, isOpi)
+			}
+		})
+	}
+}
+
+func Test_localBdevProvider_Scan(t *testing.T) {
+	log, buf := logging.NewTestLogger(t.Name())
+	defer func() {
+		if t.Failed() {
+			t.Log(buf.String())
+		}
+	}()
+
+	provider, err := NewBdevProvider(log, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A provider returned for a tier with no backend configured must not
+	// panic on a nil scanFn; it should fail loudly instead.
+	if _, err := provider.Scan(BdevScanRequest{}); err == nil {
+		t.Fatal("expected error scanning without a live SPDK backend, got nil")
+	} else if !strings.Contains(err.Error(), "not available in this build") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var scanCalls int32
+	lbp := provider.(*localBdevProvider)
+	lbp.scanFn = func(BdevScanRequest) (*BdevScanResponse, error) {
+		atomic.AddInt32(&scanCalls, 1)
+		return &BdevScanResponse{Controllers: MockNvmeControllers(1)}, nil
+	}
+
+	if _, err := provider.Scan(BdevScanRequest{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := provider.Scan(BdevScanRequest{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if calls := atomic.LoadInt32(&scanCalls); calls != 1 {
+		t.Fatalf("expected scanFn to be called once and then served from cache, called %d times", calls)
+	}
+}
+
+// fakeOpiNvmeClient is a test double for opiNvmeClient that fails
+// ListSubsystems once failAfter calls have succeeded.
+type fakeOpiNvmeClient struct {
+	calls     int32
+	failAfter int32
+	closed    int32
+}
+
+func (c *fakeOpiNvmeClient) ListSubsystems(context.Context) ([]*NvmeController, error) {
+	if atomic.AddInt32(&c.calls, 1) > atomic.LoadInt32(&c.failAfter) {
+		return nil, errors.New("connection reset by peer")
+	}
+	return nil, nil
+}
+
+func (c *fakeOpiNvmeClient) CreateBdev(context.Context, BdevTierProperties) error {
+	return nil
+}
+
+func (c *fakeOpiNvmeClient) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func Test_opiBdevProvider_healthLoop_reconnect(t *testing.T) {
+	log, buf := logging.NewTestLogger(t.Name())
+	defer func() {
+		if t.Failed() {
+			t.Log(buf.String())
+		}
+	}()
+
+	firstClient := &fakeOpiNvmeClient{failAfter: 0}
+	var dialCount int32
+
+	p := &opiBdevProvider{
+		log:                 log,
+		cfg:                 &OpiBackendConfig{TargetAddr: "dpu1:50051"},
+		healthCheckInterval: 5 * time.Millisecond,
+		dialFn: func(context.Context, *OpiBackendConfig) (opiNvmeClient, error) {
+			atomic.AddInt32(&dialCount, 1)
+			return firstClient, nil
+		},
+	}
+
+	if _, err := p.connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer p.Close()
+
+	// Force the health loop's next probe to fail, simulating a dropped
+	// DPU connection, then confirm the provider drops the dead client and
+	// redials on the next connect() call.
+	atomic.StoreInt32(&firstClient.failAfter, -1)
+
+	dropped := false
+	for i := 0; i < 100; i++ {
+		p.mu.Lock()
+		dropped = p.client == nil
+		p.mu.Unlock()
+		if dropped {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !dropped {
+		t.Fatal("expected health loop to drop the connection after a failed probe")
+	}
+	if atomic.LoadInt32(&firstClient.closed) != 1 {
+		t.Fatal("expected the dead client to be closed")
+	}
+
+	if _, err := p.connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls := atomic.LoadInt32(&dialCount); calls != 2 {
+		t.Fatalf("expected dialFn to be called twice (initial + reconnect), called %d times", calls)
+	}
+}
+
+func Test_opiBdevProvider_Close(t *testing.T) {
+	log, buf := logging.NewTestLogger(t.Name())
+	defer func() {
+		if t.Failed() {
+			t.Log(buf.String())
+		}
+	}()
+
+	client := &fakeOpiNvmeClient{failAfter: 1000}
+
+	p := &opiBdevProvider{
+		log:                 log,
+		cfg:                 &OpiBackendConfig{TargetAddr: "dpu1:50051"},
+		healthCheckInterval: 5 * time.Millisecond,
+		dialFn: func(context.Context, *OpiBackendConfig) (opiNvmeClient, error) {
+			return client, nil
+		},
+	}
+
+	if _, err := p.connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if atomic.LoadInt32(&client.closed) != 1 {
+		t.Fatal("expected Close to close the underlying client")
+	}
+
+	// Closing twice must not panic or double-close the stop channel.
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error on second Close: %s", err)
+	}
+
+	// connect() after Close() should redial and restart the health loop
+	// without reusing the closed stop channel.
+	if _, err := p.connect(context.Background()); err != nil {
+		t.Fatalf("unexpected error reconnecting after Close: %s", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error on final Close: %s", err)
+	}
+}
+func Test_localBdevProvider_Scan_expectEncryptedFromTier(t *testing.T) {
+	log, buf := logging.NewTestLogger(t.Name())
+	defer func() {
+		if t.Failed() {
+			t.Log(buf.String())
+		}
+	}()
+
+	tierCfg := NewTierConfig().
+		WithStorageClass(ClassNvme.String()).
+		WithBdevDeviceList("0000:00:00.0").
+		WithBdevEncryption(NewEnvKeyProvider("DAOS_TEST_KEY"), "AES_XTS")
+
+	provider, err := NewBdevProvider(log, tierCfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lbp := provider.(*localBdevProvider)
+
+	var scanCalls int32
+	lbp.scanFn = func(BdevScanRequest) (*BdevScanResponse, error) {
+		atomic.AddInt32(&scanCalls, 1)
+		return &BdevScanResponse{Controllers: MockNvmeControllers(1)}, nil
+	}
+
+	if _, err := provider.Scan(BdevScanRequest{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// MockNvmeControllers(1) yields an unencrypted controller, so a
+	// provider that knows its tier expects encryption must treat this as
+	// an encryption-state change and bypass the cache, without the caller
+	// ever setting ExpectEncrypted explicitly.
+	if _, err := provider.Scan(BdevScanRequest{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if calls := atomic.LoadInt32(&scanCalls); calls != 2 {
+		t.Fatalf("expected scanFn to be called twice due to encryption-state mismatch, called %d times", calls)
+	}
+}
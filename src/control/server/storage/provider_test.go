@@ -25,6 +25,9 @@ func defBdevCmpOpts() []cmp.Option {
 	return []cmp.Option{
 		// ignore these fields on most tests, as they are intentionally not stable
 		cmpopts.IgnoreFields(NvmeController{}, "HealthStats", "Serial"),
+		// KeyProvider implementations carry unexported fields and are
+		// compared indirectly via the resolved KeyRef instead.
+		cmpopts.IgnoreFields(BdevEncryption{}, "KeyProvider"),
 	}
 }
 
@@ -101,6 +104,38 @@ func Test_scanBdevs(t *testing.T) {
 				Controllers: MockNvmeControllers(3),
 			},
 		},
+		"encryption state changed; cache miss": {
+			scanReq: BdevScanRequest{
+				ExpectEncrypted: map[string]bool{"0000:00:00.0": true},
+			},
+			cache: &BdevScanResponse{
+				Controllers: MockNvmeControllers(1),
+			},
+			scanResp: &BdevScanResponse{
+				Controllers: NvmeControllers{
+					{PciAddr: "0000:00:00.0", Encrypted: true},
+				},
+			},
+			expResp: &BdevScanResponse{
+				Controllers: NvmeControllers{
+					{PciAddr: "0000:00:00.0", Encrypted: true},
+				},
+			},
+		},
+		"encryption state unchanged; use cache": {
+			scanReq: BdevScanRequest{
+				ExpectEncrypted: map[string]bool{"0000:00:00.0": false},
+			},
+			cache: &BdevScanResponse{
+				Controllers: MockNvmeControllers(1),
+			},
+			scanResp: &BdevScanResponse{
+				Controllers: MockNvmeControllers(2),
+			},
+			expResp: &BdevScanResponse{
+				Controllers: MockNvmeControllers(1),
+			},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			log, buf := logging.NewTestLogger(name)
@@ -129,6 +164,10 @@ func Test_BdevWriteRequestFromConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	os.Setenv("DAOS_TEST_KEY", "test-key-material")
+	defer os.Unsetenv("DAOS_TEST_KEY")
+	os.Unsetenv("DAOS_TEST_KEY_UNSET")
+
 	for name, tc := range map[string]struct {
 		cfg       *Config
 		getTopoFn topologyGetter
@@ -233,6 +272,212 @@ func Test_BdevWriteRequestFromConfig(t *testing.T) {
 				HotplugBusidEnd: 0x07,
 			},
 		},
+		"selector; multi-range": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevSelector(&BdevSelector{
+							BusidRanges: []string{"0x40-0x4f", "0x80-0x8f"},
+						}),
+				},
+			},
+			getTopoFn: func(context.Context) (*Topology, error) {
+				return &Topology{
+					NumaNodes: map[uint]*NumaNode{
+						0: {ID: 0, Devices: []PCIDevice{
+							{Busid: "0x45"},
+							{Busid: "0x85"},
+							{Busid: "0x99"},
+						}},
+					},
+				}, nil
+			},
+			expReq: BdevWriteConfigRequest{
+				OwnerUID: os.Geteuid(),
+				OwnerGID: os.Getegid(),
+				TierProps: []BdevTierProperties{
+					{
+						Class:      ClassNvme,
+						DeviceList: []string{"0x45", "0x85"},
+						Selector: &BdevSelector{
+							BusidRanges: []string{"0x40-0x4f", "0x80-0x8f"},
+						},
+					},
+				},
+				Hostname: hostname,
+			},
+		},
+		"selector with hotplug": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevSelector(&BdevSelector{
+							NumaNode: func() *uint { n := uint(0); return &n }(),
+						}),
+				},
+				EnableHotplug: true,
+			},
+			getTopoFn: func(context.Context) (*Topology, error) {
+				return &Topology{
+					NumaNodes: map[uint]*NumaNode{
+						0: {ID: 0, Devices: []PCIDevice{
+							{Busid: "0x10", NumaNodeID: 0},
+						}},
+					},
+				}, nil
+			},
+			expReq: BdevWriteConfigRequest{
+				OwnerUID: os.Geteuid(),
+				OwnerGID: os.Getegid(),
+				TierProps: []BdevTierProperties{
+					{
+						Class:      ClassNvme,
+						DeviceList: []string{"0x10"},
+						Selector: &BdevSelector{
+							NumaNode: func() *uint { n := uint(0); return &n }(),
+						},
+					},
+				},
+				Hostname:       hostname,
+				HotplugEnabled: true,
+			},
+		},
+		"unresolvable selector": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevSelector(&BdevSelector{Vendor: "0xffff"}),
+				},
+			},
+			getTopoFn: func(context.Context) (*Topology, error) {
+				return &Topology{
+					NumaNodes: map[uint]*NumaNode{
+						0: {ID: 0, Devices: []PCIDevice{
+							{Busid: "0x10", Vendor: "0x8086"},
+						}},
+					},
+				}, nil
+			},
+			expErr: errors.New("resolving bdev selector"),
+		},
+		"selector and device list both set": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevDeviceList("0000:70:00.0").
+						WithBdevSelector(&BdevSelector{Vendor: "0x8086"}),
+				},
+			},
+			getTopoFn: MockGetTopology,
+			expErr:    errors.New("both bdev_list and bdev_selector"),
+		},
+		"encrypted tier": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevDeviceList("0000:70:00.0").
+						WithBdevEncryption(NewEnvKeyProvider("DAOS_TEST_KEY"), "AES_XTS"),
+				},
+			},
+			getTopoFn: MockGetTopology,
+			expReq: BdevWriteConfigRequest{
+				OwnerUID: os.Geteuid(),
+				OwnerGID: os.Getegid(),
+				TierProps: []BdevTierProperties{
+					{
+						Class:      ClassNvme,
+						DeviceList: []string{"0000:70:00.0"},
+						Encryption: &BdevEncryption{
+							KeyProvider: NewEnvKeyProvider("DAOS_TEST_KEY"),
+							Cipher:      "AES_XTS",
+						},
+						KeyRef: "env:DAOS_TEST_KEY",
+					},
+				},
+				Hostname: hostname,
+			},
+		},
+		"encrypted tier with hotplug": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevBusidRange("0x70-0x7f").
+						WithBdevEncryption(NewEnvKeyProvider("DAOS_TEST_KEY"), "AES_XTS"),
+				},
+				EnableHotplug: true,
+			},
+			getTopoFn: MockGetTopology,
+			expReq: BdevWriteConfigRequest{
+				OwnerUID: os.Geteuid(),
+				OwnerGID: os.Getegid(),
+				TierProps: []BdevTierProperties{
+					{
+						Class: ClassNvme,
+						Encryption: &BdevEncryption{
+							KeyProvider: NewEnvKeyProvider("DAOS_TEST_KEY"),
+							Cipher:      "AES_XTS",
+						},
+						KeyRef: "env:DAOS_TEST_KEY",
+					},
+				},
+				Hostname:          hostname,
+				HotplugEnabled:    true,
+				HotplugBusidBegin: 0x70,
+				HotplugBusidEnd:   0x7f,
+			},
+		},
+		"mixed encrypted and plaintext tiers": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevDeviceList("0000:70:00.0").
+						WithBdevEncryption(NewEnvKeyProvider("DAOS_TEST_KEY"), "AES_XTS"),
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevDeviceList("0000:71:00.0"),
+				},
+			},
+			getTopoFn: MockGetTopology,
+			expReq: BdevWriteConfigRequest{
+				OwnerUID: os.Geteuid(),
+				OwnerGID: os.Getegid(),
+				TierProps: []BdevTierProperties{
+					{
+						Class:      ClassNvme,
+						DeviceList: []string{"0000:70:00.0"},
+						Encryption: &BdevEncryption{
+							KeyProvider: NewEnvKeyProvider("DAOS_TEST_KEY"),
+							Cipher:      "AES_XTS",
+						},
+						KeyRef: "env:DAOS_TEST_KEY",
+					},
+					{
+						Class:      ClassNvme,
+						DeviceList: []string{"0000:71:00.0"},
+					},
+				},
+				Hostname: hostname,
+			},
+		},
+		"encrypted tier; missing key": {
+			cfg: &Config{
+				Tiers: TierConfigs{
+					NewTierConfig().
+						WithStorageClass(ClassNvme.String()).
+						WithBdevDeviceList("0000:70:00.0").
+						WithBdevEncryption(NewEnvKeyProvider("DAOS_TEST_KEY_UNSET"), "AES_XTS"),
+				},
+			},
+			getTopoFn: MockGetTopology,
+			expErr:    errors.New("fetching bdev encryption key"),
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			log, buf := logging.NewTestLogger(name)
@@ -251,3 +496,127 @@ func Test_BdevWriteRequestFromConfig(t *testing.T) {
 		})
 	}
 }
+
+func Test_BdevTierProperties_AcceptsHotplugDevice(t *testing.T) {
+	for name, tc := range map[string]struct {
+		props     BdevTierProperties
+		dev       PCIDevice
+		begin     uint8
+		end       uint8
+		expAccept bool
+		expErr    error
+	}{
+		"numeric range; in range": {
+			props:     BdevTierProperties{DeviceList: []string{"0000:70:00.0"}},
+			dev:       PCIDevice{Busid: "0000:75:00.0"},
+			begin:     0x70,
+			end:       0x7f,
+			expAccept: true,
+		},
+		"numeric range; out of range": {
+			props: BdevTierProperties{DeviceList: []string{"0000:70:00.0"}},
+			dev:   PCIDevice{Busid: "0000:90:00.0"},
+			begin: 0x70,
+			end:   0x7f,
+		},
+		"selector; matches": {
+			props:     BdevTierProperties{Selector: &BdevSelector{Vendor: "0x8086"}},
+			dev:       PCIDevice{Busid: "0x41", Vendor: "0x8086"},
+			expAccept: true,
+		},
+		"selector; excluded": {
+			props: BdevTierProperties{
+				Selector: &BdevSelector{
+					Vendor:  "0x8086",
+					Exclude: &BdevSelector{BusidRanges: []string{"0x41-0x41"}},
+				},
+			},
+			dev: PCIDevice{Busid: "0x41", Vendor: "0x8086"},
+		},
+		"invalid busid": {
+			props:  BdevTierProperties{DeviceList: []string{"0000:70:00.0"}},
+			dev:    PCIDevice{Busid: "not-a-busid"},
+			begin:  0x70,
+			end:    0x7f,
+			expErr: errors.New("invalid busid"),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			accept, err := tc.props.AcceptsHotplugDevice(tc.dev, tc.begin, tc.end)
+			common.CmpErr(t, tc.expErr, err)
+			if err != nil {
+				return
+			}
+
+			if accept != tc.expAccept {
+				t.Fatalf("expected accept=%v, got %v", tc.expAccept, accept)
+			}
+		})
+	}
+}
+
+func Test_BdevWriteConfigRequest_TierForHotplugDevice(t *testing.T) {
+	rangeTier := BdevTierProperties{Class: ClassNvme, DeviceList: []string{"0000:70:00.0"}}
+	selectorTier := BdevTierProperties{
+		Class:    ClassNvme,
+		Selector: &BdevSelector{Vendor: "0x15b3"},
+	}
+
+	for name, tc := range map[string]struct {
+		req     BdevWriteConfigRequest
+		dev     PCIDevice
+		expTier *BdevTierProperties
+		expErr  error
+	}{
+		"hotplug disabled": {
+			req: BdevWriteConfigRequest{
+				HotplugEnabled:    false,
+				HotplugBusidBegin: 0x70,
+				HotplugBusidEnd:   0x7f,
+				TierProps:         []BdevTierProperties{rangeTier},
+			},
+			dev: PCIDevice{Busid: "0000:75:00.0"},
+		},
+		"numeric tier accepts": {
+			req: BdevWriteConfigRequest{
+				HotplugEnabled:    true,
+				HotplugBusidBegin: 0x70,
+				HotplugBusidEnd:   0x7f,
+				TierProps:         []BdevTierProperties{rangeTier},
+			},
+			dev:     PCIDevice{Busid: "0000:75:00.0"},
+			expTier: &rangeTier,
+		},
+		"selector tier accepts regardless of numeric window": {
+			req: BdevWriteConfigRequest{
+				HotplugEnabled:    true,
+				HotplugBusidBegin: 0x70,
+				HotplugBusidEnd:   0x7f,
+				TierProps:         []BdevTierProperties{rangeTier, selectorTier},
+			},
+			dev:     PCIDevice{Busid: "0x81", Vendor: "0x15b3"},
+			expTier: &selectorTier,
+		},
+		"no tier accepts": {
+			req: BdevWriteConfigRequest{
+				HotplugEnabled:    true,
+				HotplugBusidBegin: 0x70,
+				HotplugBusidEnd:   0x7f,
+				TierProps:         []BdevTierProperties{rangeTier, selectorTier},
+			},
+			dev: PCIDevice{Busid: "0x41", Vendor: "0x8086"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			gotTier, err := tc.req.TierForHotplugDevice(tc.dev)
+			common.CmpErr(t, tc.expErr, err)
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(tc.expTier, gotTier); diff != "" {
+				t.Fatalf("unexpected tier (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
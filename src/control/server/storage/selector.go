@@ -0,0 +1,225 @@
+//
+// (C) Copyright 2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sizeUnits maps a recognized size suffix to its value in bytes.
+var sizeUnits = map[string]uint64{
+	"B":  1,
+	"KB": 1000,
+	"MB": 1000 * 1000,
+	"GB": 1000 * 1000 * 1000,
+	"TB": 1000 * 1000 * 1000 * 1000,
+}
+
+// parseSize parses a human-readable size string such as "3.2TB" into a
+// number of bytes.
+func parseSize(str string) (uint64, error) {
+	str = strings.TrimSpace(str)
+	for _, suffix := range []string{"TB", "GB", "MB", "KB", "B"} {
+		if !strings.HasSuffix(strings.ToUpper(str), suffix) {
+			continue
+		}
+		numStr := strings.TrimSpace(str[:len(str)-len(suffix)])
+		val, err := strconv.ParseFloat(numStr, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid size %q", str)
+		}
+		return uint64(val * float64(sizeUnits[suffix])), nil
+	}
+	return 0, errors.Errorf("invalid size %q: unrecognized unit", str)
+}
+
+// BdevSelector declaratively matches bdev candidates against topology
+// attributes, as an alternative to enumerating an explicit device list or
+// a single PCI bus-ID range in a TierConfig.
+type BdevSelector struct {
+	// Vendor matches the PCI vendor ID, e.g. "0x8086".
+	Vendor string `yaml:"vendor,omitempty"`
+	// Model matches the device model against a regular expression.
+	Model string `yaml:"model,omitempty"`
+	// SizeGTE requires the device capacity to be greater than or equal to
+	// this size, e.g. "3.2TB".
+	SizeGTE string `yaml:"size_gte,omitempty"`
+	// NumaNode restricts matches to devices attached to this NUMA node.
+	NumaNode *uint `yaml:"numa_node,omitempty"`
+	// BusidRanges matches devices whose PCI bus ID falls within any of
+	// these (possibly disjoint) "0xBB-0xBB" ranges.
+	BusidRanges []string `yaml:"busid_ranges,omitempty"`
+	// Exclude carves devices that would otherwise match out of the
+	// result, e.g. to exclude a known-bad device from an otherwise
+	// matching range.
+	Exclude *BdevSelector `yaml:"exclude,omitempty"`
+}
+
+// SelectorMatch describes the outcome of testing a single candidate device
+// against a BdevSelector.
+type SelectorMatch struct {
+	Device  PCIDevice
+	Matched bool
+	Reason  string
+}
+
+// parseHexBusid parses a single PCI bus-ID such as "0000:07:00.0" or
+// "0x07" down to its bus number.
+func parseHexBusid(busid string) (uint8, error) {
+	s := busid
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		// "0000:07:00.0" -> "07"
+		rest := s[idx+1:]
+		if idx2 := strings.Index(rest, ":"); idx2 >= 0 {
+			s = rest[:idx2]
+		} else {
+			s = rest
+		}
+	}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	v, err := strconv.ParseUint(s, 16, 8)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid busid %q", busid)
+	}
+	return uint8(v), nil
+}
+
+// busidInRanges returns true if busid falls within any of the supplied
+// "0xBB-0xBB" ranges.
+func busidInRanges(busid string, ranges []string) (bool, error) {
+	val, err := parseHexBusid(busid)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range ranges {
+		begin, end, err := parseBusidRange(r)
+		if err != nil {
+			return false, err
+		}
+		if val >= begin && val <= end {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matches tests a single candidate device against the selector's positive
+// criteria, without considering Exclude.
+func (s *BdevSelector) matches(dev PCIDevice) (bool, string, error) {
+	if s.Vendor != "" && !strings.EqualFold(s.Vendor, dev.Vendor) {
+		return false, fmt.Sprintf("vendor %q != %q", dev.Vendor, s.Vendor), nil
+	}
+
+	if s.Model != "" {
+		re, err := regexp.Compile(s.Model)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "invalid model selector %q", s.Model)
+		}
+		if !re.MatchString(dev.Model) {
+			return false, fmt.Sprintf("model %q does not match %q", dev.Model, s.Model), nil
+		}
+	}
+
+	if s.SizeGTE != "" {
+		min, err := parseSize(s.SizeGTE)
+		if err != nil {
+			return false, "", err
+		}
+		if dev.SizeBytes < min {
+			return false, fmt.Sprintf("size %d < %d", dev.SizeBytes, min), nil
+		}
+	}
+
+	if s.NumaNode != nil && *s.NumaNode != dev.NumaNodeID {
+		return false, fmt.Sprintf("numa_node %d != %d", dev.NumaNodeID, *s.NumaNode), nil
+	}
+
+	if len(s.BusidRanges) > 0 {
+		ok, err := busidInRanges(dev.Busid, s.BusidRanges)
+		if err != nil {
+			return false, "", err
+		}
+		if !ok {
+			return false, fmt.Sprintf("busid %s not in %v", dev.Busid, s.BusidRanges), nil
+		}
+	}
+
+	return true, "matched", nil
+}
+
+// Matches returns true if dev satisfies the selector's criteria and is not
+// carved out by Exclude.
+func (s *BdevSelector) Matches(dev PCIDevice) (bool, string, error) {
+	ok, reason, err := s.matches(dev)
+	if err != nil || !ok {
+		return ok, reason, err
+	}
+
+	if s.Exclude != nil {
+		excluded, _, err := s.Exclude.matches(dev)
+		if err != nil {
+			return false, "", err
+		}
+		if excluded {
+			return false, "excluded", nil
+		}
+	}
+
+	return true, reason, nil
+}
+
+// DryRun evaluates the selector against every device in the topology and
+// returns the outcome for each candidate, for operator visibility.
+func (s *BdevSelector) DryRun(topo *Topology) ([]SelectorMatch, error) {
+	ids := make([]uint, 0, len(topo.NumaNodes))
+	for id := range topo.NumaNodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var out []SelectorMatch
+	for _, id := range ids {
+		for _, dev := range topo.NumaNodes[id].Devices {
+			ok, reason, err := s.Matches(dev)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, SelectorMatch{Device: dev, Matched: ok, Reason: reason})
+		}
+	}
+	return out, nil
+}
+
+// Resolve evaluates the selector against the topology and returns the set
+// of matching devices. It returns an error if no devices match, so that
+// callers can fail a config generation before anything is written to disk.
+func (s *BdevSelector) Resolve(topo *Topology) ([]PCIDevice, error) {
+	results, err := s.DryRun(topo)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []PCIDevice
+	for _, r := range results {
+		if r.Matched {
+			matched = append(matched, r.Device)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, errors.Errorf("bdev selector %+v matched no devices", s)
+	}
+
+	return matched, nil
+}
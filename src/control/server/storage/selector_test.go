@@ -0,0 +1,88 @@
+//
+// (C) Copyright 2022 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/common"
+)
+
+func numaPtr(n uint) *uint { return &n }
+
+func Test_BdevSelector_Resolve(t *testing.T) {
+	topo := &Topology{
+		NumaNodes: map[uint]*NumaNode{
+			0: {
+				ID: 0,
+				Devices: []PCIDevice{
+					{Busid: "0x41", Vendor: "0x8086", Model: "P5510", SizeBytes: 3200000000000, NumaNodeID: 0},
+					{Busid: "0x42", Vendor: "0x8086", Model: "P4510", SizeBytes: 2000000000000, NumaNodeID: 0},
+				},
+			},
+			1: {
+				ID: 1,
+				Devices: []PCIDevice{
+					{Busid: "0x81", Vendor: "0x15b3", Model: "CX6", SizeBytes: 4000000000000, NumaNodeID: 1},
+				},
+			},
+		},
+	}
+
+	for name, tc := range map[string]struct {
+		sel       *BdevSelector
+		expBusids []string
+		expErr    error
+	}{
+		"vendor match": {
+			sel:       &BdevSelector{Vendor: "0x8086"},
+			expBusids: []string{"0x41", "0x42"},
+		},
+		"model regex match": {
+			sel:       &BdevSelector{Model: "P55.*"},
+			expBusids: []string{"0x41"},
+		},
+		"size_gte": {
+			sel:       &BdevSelector{SizeGTE: "3TB"},
+			expBusids: []string{"0x41", "0x81"},
+		},
+		"numa_node": {
+			sel:       &BdevSelector{NumaNode: numaPtr(1)},
+			expBusids: []string{"0x81"},
+		},
+		"exclude carve-out": {
+			sel: &BdevSelector{
+				Vendor:  "0x8086",
+				Exclude: &BdevSelector{BusidRanges: []string{"0x42-0x42"}},
+			},
+			expBusids: []string{"0x41"},
+		},
+		"unresolvable": {
+			sel:    &BdevSelector{Vendor: "0xffff"},
+			expErr: errors.New("matched no devices"),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			matched, err := tc.sel.Resolve(topo)
+			common.CmpErr(t, tc.expErr, err)
+			if err != nil {
+				return
+			}
+
+			gotBusids := make([]string, len(matched))
+			for i, d := range matched {
+				gotBusids[i] = d.Busid
+			}
+			if diff := cmp.Diff(tc.expBusids, gotBusids); diff != "" {
+				t.Fatalf("unexpected busids (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+//
+// (C) Copyright 2021 Intel Corporation.
+//
+// SPDX-License-Identifier: BSD-2-Clause-Patent
+//
+
+package storage
+
+import "context"
+
+// PCIDevice describes a single PCIe bdev candidate discovered on a host, as
+// reported by the topology backend, for use when resolving declarative
+// bdev selectors.
+type PCIDevice struct {
+	Busid      string
+	Vendor     string
+	Model      string
+	SizeBytes  uint64
+	NumaNodeID uint
+}
+
+// NumaNode describes the PCI buses and devices attached to a single NUMA
+// node.
+type NumaNode struct {
+	ID       uint
+	PCIBuses []uint
+	Devices  []PCIDevice
+}
+
+// Topology describes the PCIe bus layout of a host, grouped by NUMA node.
+type Topology struct {
+	NumaNodes map[uint]*NumaNode
+}
+
+// topologyGetter retrieves the host PCIe topology.
+type topologyGetter func(ctx context.Context) (*Topology, error)
+
+// MockGetTopology is a topologyGetter suitable for use in tests.
+func MockGetTopology(_ context.Context) (*Topology, error) {
+	return &Topology{
+		NumaNodes: map[uint]*NumaNode{
+			0: {
+				ID:       0,
+				PCIBuses: []uint{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07},
+			},
+		},
+	}, nil
+}
+
+// defaultHotplugRange derives a default PCI bus-ID hotplug range by
+// spanning the full set of buses reported in the topology, for use when an
+// operator has not specified an explicit range.
+func defaultHotplugRange(topo *Topology) (begin, end uint8) {
+	first := true
+	for _, node := range topo.NumaNodes {
+		for _, bus := range node.PCIBuses {
+			b := uint8(bus)
+			if first {
+				begin, end = b, b
+				first = false
+				continue
+			}
+			if b < begin {
+				begin = b
+			}
+			if b > end {
+				end = b
+			}
+		}
+	}
+	return
+}